@@ -26,6 +26,8 @@ package gpool
 import (
 	"context"
 	"errors"
+	"log"
+	"runtime/debug"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -56,11 +58,19 @@ var (
 // TaskFunc task function define
 type TaskFunc func(arg interface{})
 
+// TaskFuncCtx task function variant that receives the caller's context, so a
+// long running task can observe cancellation/timeout and return promptly,
+// see SubmitCtx
+type TaskFuncCtx func(ctx context.Context, arg interface{})
+
 // Config the pool config parameter
 type Config struct {
-	Capacity        int
-	SurvivalTime    time.Duration
-	MiniCleanupTime time.Duration // mini cleanup time
+	Capacity         int
+	SurvivalTime     time.Duration
+	MiniCleanupTime  time.Duration // mini cleanup time
+	PreAlloc         bool          // eagerly build all capacity workers up front instead of lazily through sync.Pool
+	Nonblocking      bool          // Submit returns ErrOverload immediately instead of waiting when the pool is full
+	MaxBlockingTasks int           // max count of Submit calls allowed to wait for an idle worker, 0 means no limit
 }
 
 // Pool the goroutine pool
@@ -75,13 +85,25 @@ type Pool struct {
 
 	closeDone uint32
 
+	preAlloc bool // true when all capacity workers are pre-allocated, see Config.PreAlloc
+
+	nonblocking      bool  // true when Submit must not wait for an idle worker
+	maxBlockingTasks int32 // max count of Submit calls allowed to wait, 0 means no limit
+	waiting          int32 // count of Submit calls currently waiting for an idle worker
+
 	mux            sync.Mutex
 	cond           *sync.Cond
-	idleGoRoutines *list // idle go routine list
+	idleGoRoutines *list // idle go routine list, used unless preAlloc
+	idleRing       *ring // idle go routine ring, used when preAlloc
 	cache          *sync.Pool
 	wg             sync.WaitGroup
 
-	panicFunc func()
+	panicFunc func(recovered interface{}, stack []byte)
+
+	boundFn TaskFunc // set only for a PoolWithFunc, run in place of itm.task/itm.taskCtx
+
+	schedAdd    chan *timerTask // hand a new SubmitAfter/At/Every task to scheduler
+	schedCancel chan *timerTask // ask scheduler to drop a pending timerTask
 }
 
 // New new a pool with the config if there is ,other use default config
@@ -105,20 +127,101 @@ func New(c ...Config) *Pool {
 		ctx:    ctx,
 		cancel: cancel,
 
-		capacity:        int32(c[0].Capacity),
-		survivalTime:    c[0].SurvivalTime,
-		miniCleanupTime: c[0].MiniCleanupTime,
+		capacity:         int32(c[0].Capacity),
+		survivalTime:     c[0].SurvivalTime,
+		miniCleanupTime:  c[0].MiniCleanupTime,
+		preAlloc:         c[0].PreAlloc,
+		nonblocking:      c[0].Nonblocking,
+		maxBlockingTasks: int32(c[0].MaxBlockingTasks),
 
-		idleGoRoutines: newList(),
+		schedAdd:    make(chan *timerTask),
+		schedCancel: make(chan *timerTask),
 	}
 	p.cond = sync.NewCond(&p.mux)
-	p.cache = &sync.Pool{
-		New: func() interface{} { return &work{itm: make(chan item, 1), pool: p} },
+	if p.preAlloc {
+		p.idleRing = newRing(int(p.capacity))
+		for i := int32(0); i < p.capacity; i++ {
+			p.idleRing.PushBack(&work{itm: make(chan item, 1), pool: p})
+		}
+	} else {
+		p.idleGoRoutines = newList()
+		p.cache = &sync.Pool{
+			New: func() interface{} { return &work{itm: make(chan item, 1), pool: p} },
+		}
 	}
 	go p.cleanUp()
+	go p.scheduler()
 	return p
 }
 
+// NewPreAlloc new a pool with Config.PreAlloc set, eagerly building all
+// capacity workers up front rather than growing the sync.Pool lazily; this
+// trades idle memory for a steadier footprint and fewer allocations under
+// sustained load
+func NewPreAlloc(c ...Config) *Pool {
+	if len(c) == 0 {
+		c = append(c, Config{
+			Capacity:        DefaultCapacity,
+			SurvivalTime:    DefaultSurvivalTime,
+			MiniCleanupTime: DefaultMiniCleanupTime,
+		})
+	}
+	c[0].PreAlloc = true
+	return New(c[0])
+}
+
+// idleFront the first idle worker, nil if none is idle
+func (this *Pool) idleFront() *work {
+	if this.preAlloc {
+		return this.idleRing.Front()
+	}
+	return this.idleGoRoutines.Front()
+}
+
+// idleRemove remove a worker from the idle container, w must be the
+// container's current front (the only worker Submit/cleanUp ever remove)
+func (this *Pool) idleRemove(w *work) {
+	if this.preAlloc {
+		this.idleRing.RemoveFront()
+		return
+	}
+	this.idleGoRoutines.Remove(w)
+}
+
+// idlePushBack push a worker back to the idle container
+func (this *Pool) idlePushBack(w *work) {
+	if this.preAlloc {
+		this.idleRing.PushBack(w)
+		return
+	}
+	this.idleGoRoutines.PushBack(w)
+}
+
+// idleLen the count of workers currently in the idle container
+func (this *Pool) idleLen() int {
+	if this.preAlloc {
+		return this.idleRing.Len()
+	}
+	return this.idleGoRoutines.Len()
+}
+
+// idleReady false once the idle container has been torn down on close
+func (this *Pool) idleReady() bool {
+	if this.preAlloc {
+		return this.idleRing != nil
+	}
+	return this.idleGoRoutines != nil
+}
+
+// dispatch hand itm to w, starting its goroutine if it isn't already alive
+func (this *Pool) dispatch(w *work, itm item) {
+	if w.alive {
+		w.itm <- itm
+		return
+	}
+	w.run(itm)
+}
+
 func (this *Pool) cleanUp() {
 	tick := time.NewTimer(this.survivalTime)
 	defer tick.Stop()
@@ -129,13 +232,37 @@ func (this *Pool) cleanUp() {
 			nearTimeout := this.survivalTime
 			now := time.Now()
 			this.mux.Lock()
-			var next *work
-			for e := this.idleGoRoutines.Front(); e != nil; e = next {
-				if nearTimeout = now.Sub(e.markTime); nearTimeout < this.survivalTime {
-					break
+			if this.preAlloc {
+				// the ring is FIFO, not time-ordered: a never-started slot
+				// (alive == false) can sit in front of an idle worker pushed
+				// back long after it, so a never-started front entry is
+				// rotated to the back instead of stopping the scan; bounding
+				// by the ring's starting length guarantees each entry is
+				// visited at most once per tick
+				for n := this.idleRing.Len(); n > 0; n-- {
+					e := this.idleRing.Front()
+					if e == nil {
+						break
+					}
+					if !e.alive {
+						this.idleRing.RemoveFront()
+						this.idleRing.PushBack(e)
+						continue
+					}
+					if nearTimeout = now.Sub(e.markTime); nearTimeout < this.survivalTime {
+						break
+					}
+					this.idleRing.removeFront().itm <- item{stop: true}
+				}
+			} else {
+				var next *work
+				for e := this.idleGoRoutines.Front(); e != nil; e = next {
+					if nearTimeout = now.Sub(e.markTime); nearTimeout < this.survivalTime {
+						break
+					}
+					next = e.Next() // save before delete
+					this.idleGoRoutines.remove(e).itm <- item{stop: true}
 				}
-				next = e.Next() // save before delete
-				this.idleGoRoutines.remove(e).itm <- item{}
 			}
 			this.mux.Unlock()
 			if nearTimeout < this.miniCleanupTime {
@@ -144,21 +271,40 @@ func (this *Pool) cleanUp() {
 			tick.Reset(nearTimeout)
 		case <-this.ctx.Done():
 			this.mux.Lock()
-			for e := this.idleGoRoutines.Front(); e != nil; e = e.Next() {
-				e.itm <- item{} // give a nil function, make all goroutine exit
+			if this.preAlloc {
+				for i := 0; i < this.idleRing.count; i++ {
+					idx := (this.idleRing.head + i) % len(this.idleRing.buf)
+					if e := this.idleRing.buf[idx]; e.alive {
+						e.itm <- item{stop: true} // ask every alive goroutine to exit
+					}
+				}
+				this.idleRing = nil
+			} else {
+				for e := this.idleGoRoutines.Front(); e != nil; e = e.Next() {
+					e.itm <- item{stop: true} // ask every idle goroutine to exit
+				}
+				this.idleGoRoutines = nil
 			}
-			this.idleGoRoutines = nil
 			this.mux.Unlock()
 			return
 		}
 	}
 }
 
-// SetPanicHandler set panic handler
-func (this *Pool) SetPanicHandler(f func()) {
+// SetPanicHandler set panic handler, invoked with the recovered value and
+// the stack trace captured at the point of the panic
+func (this *Pool) SetPanicHandler(f func(recovered interface{}, stack []byte)) {
 	this.panicFunc = f
 }
 
+// SetPanicHandlerFunc set a panic handler that only cares a panic happened,
+// without the recovered value or stack trace
+//
+// Deprecated: use SetPanicHandler instead
+func (this *Pool) SetPanicHandlerFunc(f func()) {
+	this.panicFunc = func(interface{}, []byte) { f() }
+}
+
 // Len returns the currently running goroutines
 func (this *Pool) Len() int {
 	return int(atomic.LoadInt32(&this.running))
@@ -169,11 +315,20 @@ func (this *Pool) Cap() int {
 	return int(atomic.LoadInt32(&this.capacity))
 }
 
-// Adjust adjust the capacity of the pools goroutines
+// Adjust adjust the capacity of the pools goroutines. On a PreAlloc pool
+// growth is clamped to the capacity it was created with, since the idle ring
+// backing its workers has a fixed size; shrinking is unaffected
 func (this *Pool) Adjust(size int) {
 	if size < 0 || this.Cap() == size {
 		return
 	}
+	if this.preAlloc && this.idleRing != nil {
+		// the ring backing idle workers is sized once in New and cannot grow,
+		// so a PreAlloc pool can only ever use up to its original capacity
+		if ringCap := len(this.idleRing.buf); size > ringCap {
+			size = ringCap
+		}
+	}
 	atomic.StoreInt32(&this.capacity, int32(size))
 }
 
@@ -182,12 +337,19 @@ func (this *Pool) Free() int {
 	return this.Cap() - this.Len()
 }
 
-// Idle return the goroutines has running but in idle(no task work)
+// Waiting return the count of Submit calls currently blocked waiting for an idle worker
+func (this *Pool) Waiting() int {
+	return int(atomic.LoadInt32(&this.waiting))
+}
+
+// Idle return the goroutines has running but in idle(no task work). In
+// PreAlloc mode this also counts pre-built workers that have never run a
+// task yet, since they sit in the same ring slot waiting to be dispatched
 func (this *Pool) Idle() int {
 	var cnt int
 	this.mux.Lock()
-	if this.idleGoRoutines != nil {
-		cnt = this.idleGoRoutines.Len()
+	if this.idleReady() {
+		cnt = this.idleLen()
 	}
 	this.mux.Unlock()
 	return cnt
@@ -203,6 +365,7 @@ func (this *Pool) Close(grace bool) error {
 	if this.closeDone == onWork { // check again,make sure
 		this.cancel()
 		atomic.StoreUint32(&this.closeDone, closed)
+		this.cond.Broadcast() // wake any Submit/SubmitCtx waiters, they observe closed and return ErrClosed
 	}
 	this.mux.Unlock()
 	if grace {
@@ -211,49 +374,181 @@ func (this *Pool) Close(grace bool) error {
 	return nil
 }
 
+// CloseTimeout closes the pool and waits for outstanding workers to exit like
+// Close(true), but gives up after timeout and returns context.DeadlineExceeded
+// instead of blocking forever
+func (this *Pool) CloseTimeout(timeout time.Duration) error {
+	if err := this.Close(false); err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		this.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-time.After(timeout):
+		return context.DeadlineExceeded
+	}
+}
+
 // Submit submits a task with arg
 func (this *Pool) Submit(f TaskFunc, arg interface{}) error {
+	if f == nil {
+		return ErrInvalidFunc
+	}
+	return this.submitItem(item{task: f, arg: arg})
+}
+
+// submitItem is the shared implementation behind Submit and PoolWithFunc.Invoke
+func (this *Pool) submitItem(itm item) error {
+	var w *work
+
+	if atomic.LoadUint32(&this.closeDone) == closed {
+		return ErrClosed
+	}
+
+	this.mux.Lock()
+	if this.closeDone == closed || !this.idleReady() { // check again,make sure
+		this.mux.Unlock()
+		return ErrClosed
+	}
+
+	if w = this.idleFront(); w != nil {
+		this.idleRemove(w)
+		this.mux.Unlock()
+		this.dispatch(w, itm)
+		return nil
+	}
+
+	// actual goroutines maybe greater than cap, when race, but it will overload and return to normal in goroutine
+	if !this.preAlloc && this.Free() > 0 {
+		this.mux.Unlock()
+		w = this.cache.Get().(*work)
+		w.run(itm)
+		return nil
+	}
+
+	if this.nonblocking {
+		this.mux.Unlock()
+		return ErrOverload
+	}
+
+	if waiting := atomic.AddInt32(&this.waiting, 1); this.maxBlockingTasks > 0 && waiting > this.maxBlockingTasks {
+		atomic.AddInt32(&this.waiting, -1)
+		this.mux.Unlock()
+		return ErrOverload
+	}
+
+	for {
+		this.cond.Wait()
+		if this.closeDone == closed {
+			atomic.AddInt32(&this.waiting, -1)
+			this.mux.Unlock()
+			return ErrClosed
+		}
+		if w = this.idleFront(); w != nil {
+			this.idleRemove(w)
+			break
+		}
+	}
+	atomic.AddInt32(&this.waiting, -1)
+	this.mux.Unlock()
+	this.dispatch(w, itm)
+	return nil
+}
+
+// SubmitCtx submits a task bound to ctx: waiting for an idle worker aborts
+// with ctx.Err() as soon as ctx is canceled, and the task itself runs
+// through f with ctx so it can observe the caller's deadline and return
+// promptly instead of tying up a worker until it finishes on its own
+func (this *Pool) SubmitCtx(ctx context.Context, f TaskFuncCtx, arg interface{}) error {
 	var w *work
 
 	if f == nil {
 		return ErrInvalidFunc
 	}
 
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	if atomic.LoadUint32(&this.closeDone) == closed {
 		return ErrClosed
 	}
 
 	this.mux.Lock()
-	if this.closeDone == closed || this.idleGoRoutines == nil { // check again,make sure
+	if this.closeDone == closed || !this.idleReady() { // check again,make sure
 		this.mux.Unlock()
 		return ErrClosed
 	}
 
-	itm := item{f, arg}
-	if w = this.idleGoRoutines.Front(); w != nil {
-		this.idleGoRoutines.Remove(w)
+	itm := item{taskCtx: f, ctx: ctx, arg: arg}
+	if w = this.idleFront(); w != nil {
+		this.idleRemove(w)
 		this.mux.Unlock()
-		w.itm <- itm
+		this.dispatch(w, itm)
 		return nil
 	}
 
 	// actual goroutines maybe greater than cap, when race, but it will overload and return to normal in goroutine
-	if this.Free() > 0 {
+	if !this.preAlloc && this.Free() > 0 {
 		this.mux.Unlock()
 		w = this.cache.Get().(*work)
 		w.run(itm)
 		return nil
 	}
 
+	if this.nonblocking {
+		this.mux.Unlock()
+		return ErrOverload
+	}
+
+	if waiting := atomic.AddInt32(&this.waiting, 1); this.maxBlockingTasks > 0 && waiting > this.maxBlockingTasks {
+		atomic.AddInt32(&this.waiting, -1)
+		this.mux.Unlock()
+		return ErrOverload
+	}
+
+	// watchdog: wake the waiter with a Broadcast once ctx is done, so the
+	// cond.Wait loop below can re-check ctx and bail out promptly
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			this.mux.Lock()
+			this.cond.Broadcast()
+			this.mux.Unlock()
+		case <-done:
+		}
+	}()
+
+	var err error
 	for {
+		if err = ctx.Err(); err != nil {
+			break
+		}
 		this.cond.Wait()
-		if w = this.idleGoRoutines.Front(); w != nil {
-			this.idleGoRoutines.Remove(w)
+		if this.closeDone == closed {
+			err = ErrClosed
+			break
+		}
+		if w = this.idleFront(); w != nil {
+			this.idleRemove(w)
 			break
 		}
 	}
+	atomic.AddInt32(&this.waiting, -1)
 	this.mux.Unlock()
-	w.itm <- itm
+	if err != nil {
+		return err
+	}
+	this.dispatch(w, itm)
 	return nil
 }
 
@@ -273,7 +568,7 @@ func (this *Pool) push(w *work) error {
 		this.mux.Unlock()
 		return ErrClosed
 	}
-	this.idleGoRoutines.PushBack(w)
+	this.idlePushBack(w)
 	this.cond.Signal()
 	this.mux.Unlock()
 	return nil
@@ -282,24 +577,71 @@ func (this *Pool) push(w *work) error {
 func (this *work) run(itm item) {
 	this.pool.wg.Add(1)
 	atomic.AddInt32(&this.pool.running, 1)
+	this.alive = true
 	go func() {
 		defer func() {
 			this.pool.wg.Done()
 			atomic.AddInt32(&this.pool.running, -1)
-			this.pool.cache.Put(this)
-			if r := recover(); r != nil && this.pool.panicFunc != nil {
-				this.pool.panicFunc()
+			this.alive = false
+			if this.pool.cache != nil {
+				this.pool.cache.Put(this)
+			} else if atomic.LoadUint32(&this.pool.closeDone) != closed {
+				// PreAlloc mode: return the slot to the ring so capacity isn't lost
+				this.markTime = time.Time{}
+				this.pool.mux.Lock()
+				if this.pool.idleRing != nil {
+					this.pool.idleRing.PushBack(this)
+				}
+				this.pool.mux.Unlock()
+			}
+			if r := recover(); r != nil {
+				stack := debug.Stack()
+				if this.pool.panicFunc != nil {
+					this.pool.panicFunc(r, stack)
+				} else {
+					log.Printf("gpool: task panic: %v\n%s", r, stack)
+				}
 			}
 		}()
 
 		for {
-			itm.task(itm.arg)
+			switch {
+			case itm.taskCtx != nil:
+				itm.taskCtx(itm.ctx, itm.arg)
+			case itm.task != nil:
+				itm.task(itm.arg)
+			default:
+				this.pool.boundFn(itm.arg)
+			}
 			if this.pool.push(this) != nil {
 				return
 			}
-			if itm = <-this.itm; itm.task == nil {
+			if itm = <-this.itm; itm.stop {
 				return
 			}
 		}
 	}()
 }
+
+// PoolWithFunc a goroutine pool bound to a single TaskFunc set once at
+// construction, trading Submit's per-call function argument for a leaner
+// Invoke that only sends the argument; see NewWithFunc
+type PoolWithFunc struct {
+	*Pool
+}
+
+// NewWithFunc new a PoolWithFunc bound to fn, reusing the same work/idle-list
+// machinery as Pool
+func NewWithFunc(fn TaskFunc, c ...Config) *PoolWithFunc {
+	p := New(c...)
+	p.boundFn = fn
+	return &PoolWithFunc{Pool: p}
+}
+
+// Invoke submits arg to run through the pool's bound function
+func (this *PoolWithFunc) Invoke(arg interface{}) error {
+	if this.boundFn == nil {
+		return ErrInvalidFunc
+	}
+	return this.submitItem(item{arg: arg})
+}
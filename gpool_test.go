@@ -23,6 +23,8 @@
 package gpool
 
 import (
+	"context"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -37,7 +39,7 @@ type task struct {
 	args int
 }
 
-func (sf task) poolFunc() {
+func (sf task) poolFunc(arg interface{}) {
 	time.Sleep(time.Duration(sf.args) * time.Millisecond)
 }
 
@@ -45,21 +47,19 @@ func BenchmarkGoroutineUnlimit(b *testing.B) {
 	tsk := task{benchParam}
 	for i := 0; i < b.N; i++ {
 		for j := 0; j < benchRunCnt; j++ {
-			go tsk.poolFunc()
+			go tsk.poolFunc(nil)
 		}
 	}
 }
 
 func BenchmarkPoolUnlimit(b *testing.B) {
-	p := New(Config{benchPoolCap, time.Second * 1, time.Second * 10})
-	defer p.CloseGrace()
+	p := New(Config{Capacity: benchPoolCap, SurvivalTime: time.Second * 1, MiniCleanupTime: time.Second * 10})
+	defer p.Close(true)
 	tsk := task{benchParam}
 	b.StartTimer()
 	for i := 0; i < b.N; i++ {
 		for j := 0; j < benchRunCnt; j++ {
-			_ = p.Submit(func() {
-				tsk.poolFunc()
-			})
+			_ = p.Submit(tsk.poolFunc, nil)
 		}
 	}
 	b.StopTimer()
@@ -68,7 +68,7 @@ func BenchmarkPoolUnlimit(b *testing.B) {
 func TestNewWithConfig(t *testing.T) {
 	t.Run("default config", func(t *testing.T) {
 		p := New()
-		defer p.CloseGrace()
+		defer p.Close(true)
 		if p.Cap() != DefaultCapacity {
 			t.Errorf("Pool.Cap() = %v, want %v", p.Cap(), DefaultCapacity)
 		}
@@ -84,8 +84,8 @@ func TestNewWithConfig(t *testing.T) {
 	})
 
 	t.Run("invalid config cap use default", func(t *testing.T) {
-		p := New(Config{-1, time.Second * 1, DefaultMiniCleanupTime})
-		defer p.CloseGrace()
+		p := New(Config{Capacity: -1, SurvivalTime: time.Second * 1, MiniCleanupTime: DefaultMiniCleanupTime})
+		defer p.Close(true)
 		if p.Cap() != DefaultCapacity {
 			t.Errorf("Pool.Cap() = %v, want %v", p.Cap(), DefaultCapacity)
 		}
@@ -99,8 +99,8 @@ func TestNewWithConfig(t *testing.T) {
 
 	t.Run("use user config", func(t *testing.T) {
 		want := 10000
-		p := New(Config{want, time.Second * 1, DefaultMiniCleanupTime})
-		defer p.CloseGrace()
+		p := New(Config{Capacity: want, SurvivalTime: time.Second * 1, MiniCleanupTime: DefaultMiniCleanupTime})
+		defer p.Close(true)
 		if p.Cap() != want {
 			t.Errorf("Pool.Cap() = %v, want %v", p.Cap(), want)
 		}
@@ -112,39 +112,74 @@ func TestNewWithConfig(t *testing.T) {
 		}
 	})
 
+	t.Run("pre alloc config", func(t *testing.T) {
+		want := 8
+		p := NewPreAlloc(Config{Capacity: want, SurvivalTime: time.Second * 1, MiniCleanupTime: DefaultMiniCleanupTime})
+		defer p.Close(true)
+		if p.Cap() != want {
+			t.Errorf("Pool.Cap() = %v, want %v", p.Cap(), want)
+		}
+		if p.Idle() != want {
+			t.Errorf("Pool.Idle() = %v, want %v", p.Idle(), want)
+		}
+		p.Adjust(want + 4) // growth is clamped, the idle ring can't grow past its original size
+		if p.Cap() != want {
+			t.Errorf("after Pool.Adjust growth, Pool.Cap() = %v, want %v", p.Cap(), want)
+		}
+		p.Adjust(want - 2) // shrinking is unaffected
+		if p.Cap() != want-2 {
+			t.Errorf("after Pool.Adjust shrink, Pool.Cap() = %v, want %v", p.Cap(), want-2)
+		}
+	})
+}
+
+func TestPreAllocIdleReclaim(t *testing.T) {
+	// never-started slots (W3) sit in front of an idle, already-used worker
+	// (W1) in the ring's FIFO order; the cleanup scan must not stop at W3
+	// and leave W1 running forever
+	p := NewPreAlloc(Config{Capacity: 3, SurvivalTime: 20 * time.Millisecond, MiniCleanupTime: DefaultMiniCleanupTime})
+	defer p.Close(true)
+
+	_ = p.Submit(func(interface{}) {}, nil)
+	time.Sleep(10 * time.Millisecond)
+	_ = p.Submit(func(interface{}) {}, nil)
+
+	time.Sleep(250 * time.Millisecond)
+	if p.Len() != 0 {
+		t.Errorf("Pool.Len() = %v, want %v", p.Len(), 0)
+	}
 }
 
 func TestWithWork(t *testing.T) {
 	t.Run("invalid function task", func(t *testing.T) {
 		p := New()
-		defer p.CloseGrace()
-		err := p.Submit(nil)
-		if err == nil {
+		defer p.Close(true)
+		err := p.Submit(nil, nil)
+		if err != ErrInvalidFunc {
 			t.Errorf("Pool.Submit() Err = %v, want %v", err, ErrInvalidFunc)
 		}
 	})
 
 	t.Run("do task when pool is closed", func(t *testing.T) {
 		p := New()
-		p.CloseGrace()
+		p.Close(true)
 		tsk := task{1}
-		time.Sleep(200 * time.Millisecond)
-		err := p.Submit(tsk.poolFunc)
-		if err == nil {
+		err := p.Submit(tsk.poolFunc, nil)
+		if err != ErrClosed {
 			t.Errorf("Pool.Submit() Err = %v, want %v", err, ErrClosed)
 		}
 	})
 
 	t.Run("check pool parameters", func(t *testing.T) {
 		p := New()
-		defer p.CloseGrace()
+		defer p.Close(true)
 		tsk := task{1}
-		err := p.Submit(tsk.poolFunc)
+		err := p.Submit(tsk.poolFunc, nil)
 		if err != nil {
 			t.Errorf("Pool.Submit() Err = %v, want %v", err, nil)
 		}
-		_ = p.Submit(tsk.poolFunc)
-		_ = p.Submit(tsk.poolFunc)
+		_ = p.Submit(tsk.poolFunc, nil)
+		_ = p.Submit(tsk.poolFunc, nil)
 		if p.Cap() != DefaultCapacity {
 			t.Errorf("Pool.Cap() = %v, want %v", p.Cap(), DefaultCapacity)
 		}
@@ -186,14 +221,13 @@ func TestWithWork(t *testing.T) {
 	t.Run("close by user", func(t *testing.T) {
 		p := New()
 		tsk := task{1}
-		_ = p.Submit(tsk.poolFunc)
-		_ = p.Submit(tsk.poolFunc)
+		_ = p.Submit(tsk.poolFunc, nil)
+		_ = p.Submit(tsk.poolFunc, nil)
 		time.Sleep(time.Millisecond * 2)
-		_ = p.Submit(tsk.poolFunc)
-		p.CloseGrace()
-		p.CloseGrace() // close twice
+		_ = p.Submit(tsk.poolFunc, nil)
+		p.Close(true)
+		p.Close(true) // close twice
 		t.Log("all goroutine done")
-		time.Sleep(time.Millisecond * 100)
 		if p.Len() != 0 {
 			t.Errorf("Pool.Len() = %v, want %v", p.Len(), 0)
 		}
@@ -204,27 +238,236 @@ func TestWithWork(t *testing.T) {
 			t.Errorf("Pool.Idle() = %v, want %v", p.Idle(), 0)
 		}
 	})
+
+	t.Run("close with timeout", func(t *testing.T) {
+		p := New()
+		_ = p.Submit(task{500}.poolFunc, nil)
+		if err := p.CloseTimeout(10 * time.Millisecond); err != context.DeadlineExceeded {
+			t.Errorf("Pool.CloseTimeout() Err = %v, want %v", err, context.DeadlineExceeded)
+		}
+	})
+
+	t.Run("close with timeout, tasks finish in time", func(t *testing.T) {
+		p := New()
+		_ = p.Submit(task{1}.poolFunc, nil)
+		if err := p.CloseTimeout(time.Second); err != nil {
+			t.Errorf("Pool.CloseTimeout() Err = %v, want %v", err, nil)
+		}
+	})
 }
 
 func TestWithFullWork(t *testing.T) {
-	p := New(Config{5, time.Second * 1, DefaultMiniCleanupTime})
-	defer p.CloseGrace()
+	p := New(Config{Capacity: 5, SurvivalTime: time.Second * 1, MiniCleanupTime: DefaultMiniCleanupTime})
+	defer p.Close(true)
 	tsk := task{1}
 	for i := 0; i < 10; i++ {
-		_ = p.Submit(tsk.poolFunc)
+		_ = p.Submit(tsk.poolFunc, nil)
 	}
 	t.Log("pool full then wait for idle goroutine")
 }
 
 func TestWithWorkPanic(t *testing.T) {
 	p := New()
-	defer p.CloseGrace()
-	p.SetPanicHandler(func() {
-		t.Log("panic happen")
+	defer p.Close(true)
+
+	var recovered interface{}
+	var stack []byte
+	done := make(chan struct{})
+	p.SetPanicHandler(func(r interface{}, s []byte) {
+		recovered, stack = r, s
+		close(done)
+	})
+
+	_ = p.Submit(func(interface{}) {
+		panic("panic happen")
+	}, nil)
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("panic handler was not invoked")
+	}
+	if recovered != "panic happen" {
+		t.Errorf("panic handler recovered = %v, want %v", recovered, "panic happen")
+	}
+	if len(stack) == 0 {
+		t.Error("panic handler stack was empty")
+	}
+}
+
+func TestNonblocking(t *testing.T) {
+	p := New(Config{Capacity: 1, SurvivalTime: time.Second, MiniCleanupTime: DefaultMiniCleanupTime, Nonblocking: true})
+	defer p.Close(true)
+
+	_ = p.Submit(task{50}.poolFunc, nil)
+	if err := p.Submit(task{1}.poolFunc, nil); err != ErrOverload {
+		t.Errorf("Pool.Submit() Err = %v, want %v", err, ErrOverload)
+	}
+}
+
+func TestMaxBlockingTasks(t *testing.T) {
+	p := New(Config{Capacity: 1, SurvivalTime: time.Second, MiniCleanupTime: DefaultMiniCleanupTime, MaxBlockingTasks: 1})
+	defer p.Close(true)
+
+	_ = p.Submit(task{100}.poolFunc, nil) // occupies the one worker
+
+	errs := make(chan error, 1)
+	go func() { errs <- p.Submit(task{1}.poolFunc, nil) }() // allowed to wait
+	time.Sleep(20 * time.Millisecond)
+	if p.Waiting() != 1 {
+		t.Errorf("Pool.Waiting() = %v, want %v", p.Waiting(), 1)
+	}
+	if err := p.Submit(task{1}.poolFunc, nil); err != ErrOverload {
+		t.Errorf("Pool.Submit() Err = %v, want %v", err, ErrOverload)
+	}
+
+	if err := <-errs; err != nil {
+		t.Errorf("blocked Pool.Submit() Err = %v, want %v", err, nil)
+	}
+}
+
+func TestSubmitCtx(t *testing.T) {
+	t.Run("task observes cancellation", func(t *testing.T) {
+		p := New(Config{Capacity: 1, SurvivalTime: time.Second, MiniCleanupTime: DefaultMiniCleanupTime})
+		defer p.Close(true)
+
+		done := make(chan struct{})
+		ctx, cancel := context.WithCancel(context.Background())
+		err := p.SubmitCtx(ctx, func(ctx context.Context, arg interface{}) {
+			<-ctx.Done()
+			close(done)
+		}, nil)
+		if err != nil {
+			t.Fatalf("Pool.SubmitCtx() Err = %v, want %v", err, nil)
+		}
+		cancel()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("task did not observe ctx cancellation")
+		}
+	})
+
+	t.Run("wait aborts once ctx is canceled", func(t *testing.T) {
+		p := New(Config{Capacity: 1, SurvivalTime: time.Second, MiniCleanupTime: DefaultMiniCleanupTime})
+		defer p.Close(true)
+
+		_ = p.Submit(task{200}.poolFunc, nil) // occupies the one worker
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		err := p.SubmitCtx(ctx, func(context.Context, interface{}) {}, nil)
+		if err != context.DeadlineExceeded {
+			t.Errorf("Pool.SubmitCtx() Err = %v, want %v", err, context.DeadlineExceeded)
+		}
+	})
+
+	t.Run("already canceled ctx", func(t *testing.T) {
+		p := New()
+		defer p.Close(true)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err := p.SubmitCtx(ctx, func(context.Context, interface{}) {}, nil)
+		if err != context.Canceled {
+			t.Errorf("Pool.SubmitCtx() Err = %v, want %v", err, context.Canceled)
+		}
+	})
+}
+
+func TestPoolWithFunc(t *testing.T) {
+	var sum int32
+	p := NewWithFunc(func(arg interface{}) {
+		atomic.AddInt32(&sum, int32(arg.(int)))
+	})
+	defer p.Close(true)
+
+	for i := 1; i <= 5; i++ {
+		if err := p.Invoke(i); err != nil {
+			t.Errorf("PoolWithFunc.Invoke() Err = %v, want %v", err, nil)
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	if atomic.LoadInt32(&sum) != 15 {
+		t.Errorf("sum = %v, want %v", sum, 15)
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	t.Run("SubmitAfter", func(t *testing.T) {
+		p := New()
+		defer p.Close(true)
+
+		done := make(chan struct{})
+		start := time.Now()
+		_, err := p.SubmitAfter(50*time.Millisecond, func(interface{}) { close(done) }, nil)
+		if err != nil {
+			t.Fatalf("Pool.SubmitAfter() Err = %v, want %v", err, nil)
+		}
+		select {
+		case <-done:
+			if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+				t.Errorf("fired after %v, want >= %v", elapsed, 50*time.Millisecond)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("SubmitAfter task never fired")
+		}
 	})
 
-	_ = p.Submit(func() {
-		panic("painc happen")
+	t.Run("SubmitAfter cancel", func(t *testing.T) {
+		p := New()
+		defer p.Close(true)
+
+		fired := make(chan struct{}, 1)
+		cancel, err := p.SubmitAfter(50*time.Millisecond, func(interface{}) { fired <- struct{}{} }, nil)
+		if err != nil {
+			t.Fatalf("Pool.SubmitAfter() Err = %v, want %v", err, nil)
+		}
+		cancel()
+		select {
+		case <-fired:
+			t.Error("canceled task fired anyway")
+		case <-time.After(100 * time.Millisecond):
+		}
+	})
+
+	t.Run("SubmitEvery", func(t *testing.T) {
+		p := New()
+		defer p.Close(true)
+
+		var count int32
+		cancel, err := p.SubmitEvery(10*time.Millisecond, func(interface{}) { atomic.AddInt32(&count, 1) }, nil)
+		if err != nil {
+			t.Fatalf("Pool.SubmitEvery() Err = %v, want %v", err, nil)
+		}
+		time.Sleep(55 * time.Millisecond)
+		cancel()
+		if atomic.LoadInt32(&count) < 2 {
+			t.Errorf("count = %v, want >= %v", count, 2)
+		}
+	})
+
+	t.Run("SubmitAt", func(t *testing.T) {
+		p := New()
+		defer p.Close(true)
+
+		done := make(chan struct{})
+		_, err := p.SubmitAt(time.Now().Add(20*time.Millisecond), func(interface{}) { close(done) }, nil)
+		if err != nil {
+			t.Fatalf("Pool.SubmitAt() Err = %v, want %v", err, nil)
+		}
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("SubmitAt task never fired")
+		}
+	})
+
+	t.Run("scheduled task dropped when pool already closed", func(t *testing.T) {
+		p := New()
+		p.Close(true)
+		if _, err := p.SubmitAfter(time.Millisecond, func(interface{}) {}, nil); err != ErrClosed {
+			t.Errorf("Pool.SubmitAfter() Err = %v, want %v", err, ErrClosed)
+		}
 	})
-	time.Sleep(time.Second * 1)
 }
@@ -0,0 +1,163 @@
+// MIT License
+//
+// Copyright (c) 2019 jiang
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gpool
+
+import (
+	"context"
+	"time"
+)
+
+// item the task and its argument delivered to a worker goroutine. At most one
+// of task/taskCtx is set; ctx is only meaningful with taskCtx. Neither is set
+// when the pool is a PoolWithFunc, which runs its bound fn instead. stop asks
+// the worker goroutine to exit instead of running anything
+type item struct {
+	task    TaskFunc
+	taskCtx TaskFuncCtx
+	ctx     context.Context
+	arg     interface{}
+	stop    bool
+}
+
+// work a pool goroutine, it doubles as a node of the idle list so pushing
+// and popping an idle worker needs no extra per-node allocation
+type work struct {
+	pool     *Pool
+	itm      chan item
+	markTime time.Time
+	alive    bool // true once its goroutine has been started and is waiting on itm
+
+	prev, next *work
+	owner      *list // the list this node is currently linked into, nil once removed
+}
+
+// Next returns the next idle worker after this one in the idle list, nil at
+// the end; owner is checked rather than next itself so the list's root
+// sentinel is never mistaken for a real worker
+func (this *work) Next() *work {
+	if p := this.next; this.owner != nil && p != &this.owner.root {
+		return p
+	}
+	return nil
+}
+
+// list a minimal intrusive doubly linked list of idle workers
+type list struct {
+	root work // sentinel, root.next is the front, root.prev is the back
+	len  int
+}
+
+// newList new an empty idle list
+func newList() *list {
+	l := &list{}
+	l.root.next = &l.root
+	l.root.prev = &l.root
+	return l
+}
+
+// Len the count of idle workers in the list
+func (this *list) Len() int {
+	return this.len
+}
+
+// Front the first idle worker, nil if the list is empty
+func (this *list) Front() *work {
+	if this.len == 0 {
+		return nil
+	}
+	return this.root.next
+}
+
+// PushBack push a worker to the back of the idle list
+func (this *list) PushBack(w *work) {
+	w.prev = this.root.prev
+	w.next = &this.root
+	this.root.prev.next = w
+	this.root.prev = w
+	w.owner = this
+	this.len++
+}
+
+// Remove remove a worker from the idle list
+func (this *list) Remove(w *work) {
+	w.prev.next = w.next
+	w.next.prev = w.prev
+	w.prev = nil
+	w.next = nil
+	w.owner = nil
+	this.len--
+}
+
+// remove like Remove but returns the removed worker, handy while iterating
+func (this *list) remove(w *work) *work {
+	this.Remove(w)
+	return w
+}
+
+// ring a fixed-size circular buffer of idle workers, used in pre-allocated mode
+// where every worker is built up front, so no worker goes through sync.Pool
+type ring struct {
+	buf   []*work
+	head  int
+	tail  int
+	count int
+}
+
+// newRing new an empty ring able to hold up to size idle workers
+func newRing(size int) *ring {
+	return &ring{buf: make([]*work, size)}
+}
+
+// Len the count of idle workers in the ring
+func (this *ring) Len() int {
+	return this.count
+}
+
+// Front the first idle worker, nil if the ring is empty
+func (this *ring) Front() *work {
+	if this.count == 0 {
+		return nil
+	}
+	return this.buf[this.head]
+}
+
+// PushBack push a worker to the back of the ring
+func (this *ring) PushBack(w *work) {
+	this.buf[this.tail] = w
+	this.tail = (this.tail + 1) % len(this.buf)
+	this.count++
+}
+
+// RemoveFront pop the front worker off the ring
+func (this *ring) RemoveFront() {
+	this.buf[this.head] = nil
+	this.head = (this.head + 1) % len(this.buf)
+	this.count--
+}
+
+// removeFront like RemoveFront but returns the removed worker, handy while iterating
+func (this *ring) removeFront() *work {
+	w := this.buf[this.head]
+	this.RemoveFront()
+	return w
+}
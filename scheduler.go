@@ -0,0 +1,173 @@
+// MIT License
+//
+// Copyright (c) 2019 jiang
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package gpool
+
+import (
+	"container/heap"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// timerTask one scheduled submission pending in the pool's timer heap
+type timerTask struct {
+	at       time.Time     // next fire time
+	interval time.Duration // >0 re-arms itself after firing, see SubmitEvery
+	f        TaskFunc
+	arg      interface{}
+	index    int // heap index, maintained by container/heap
+}
+
+// timerHeap a min-heap of timerTask ordered by at, implements container/heap.Interface
+type timerHeap []*timerTask
+
+func (this timerHeap) Len() int { return len(this) }
+
+func (this timerHeap) Less(i, j int) bool { return this[i].at.Before(this[j].at) }
+
+func (this timerHeap) Swap(i, j int) {
+	this[i], this[j] = this[j], this[i]
+	this[i].index = i
+	this[j].index = j
+}
+
+func (this *timerHeap) Push(x interface{}) {
+	t := x.(*timerTask)
+	t.index = len(*this)
+	*this = append(*this, t)
+}
+
+func (this *timerHeap) Pop() interface{} {
+	old := *this
+	n := len(old)
+	t := old[n-1]
+	old[n-1] = nil
+	t.index = -1
+	*this = old[:n-1]
+	return t
+}
+
+// scheduler is the single goroutine owning the timer heap, started in New
+// alongside cleanUp; it is the only goroutine that ever touches the heap, so
+// schedAdd/schedCancel hand it tasks rather than locking a shared structure
+func (this *Pool) scheduler() {
+	h := &timerHeap{}
+	heap.Init(h)
+
+	for {
+		var fireC <-chan time.Time
+		if h.Len() > 0 {
+			if d := time.Until((*h)[0].at); d > 0 {
+				fireC = time.After(d)
+			} else {
+				fireC = closedTimeC
+			}
+		}
+
+		select {
+		case t := <-this.schedAdd:
+			heap.Push(h, t)
+		case t := <-this.schedCancel:
+			if t.index >= 0 && t.index < h.Len() && (*h)[t.index] == t {
+				heap.Remove(h, t.index)
+			}
+		case <-fireC:
+			now := time.Now()
+			for h.Len() > 0 && !(*h)[0].at.After(now) {
+				t := heap.Pop(h).(*timerTask)
+				// Submit must not run inline: it can block in cond.Wait() on a
+				// saturated pool, and this goroutine is the only reader of
+				// schedAdd/schedCancel, so that would freeze every other
+				// pending and future timer along with it.
+				go this.runScheduled(t)
+				if t.interval > 0 {
+					t.at = now.Add(t.interval)
+					heap.Push(h, t)
+				}
+			}
+		case <-this.ctx.Done():
+			return
+		}
+	}
+}
+
+// closedTimeC a pre-closed channel, used to fire immediately for an already
+// due timerTask without allocating a fresh zero-duration timer
+var closedTimeC = func() <-chan time.Time {
+	c := make(chan time.Time)
+	close(c)
+	return c
+}()
+
+// runScheduled submits a fired timerTask, respecting Nonblocking/overload
+// semantics; a dropped task (ErrOverload/ErrClosed) is silently lost to the
+// caller, who has no channel back to it, so it is logged the same way an
+// unhandled panic is
+func (this *Pool) runScheduled(t *timerTask) {
+	if err := this.Submit(t.f, t.arg); err != nil {
+		log.Printf("gpool: scheduled task dropped: %v", err)
+	}
+}
+
+// schedule registers f/arg to run at "at", re-arming every interval afterwards
+// when interval > 0; the returned cancel removes it from the heap in O(log n)
+func (this *Pool) schedule(at time.Time, interval time.Duration, f TaskFunc, arg interface{}) (cancel func(), err error) {
+	if f == nil {
+		return nil, ErrInvalidFunc
+	}
+	if atomic.LoadUint32(&this.closeDone) == closed {
+		return nil, ErrClosed
+	}
+
+	t := &timerTask{at: at, interval: interval, f: f, arg: arg, index: -1}
+	select {
+	case this.schedAdd <- t:
+	case <-this.ctx.Done():
+		return nil, ErrClosed
+	}
+
+	return func() {
+		select {
+		case this.schedCancel <- t:
+		case <-this.ctx.Done():
+		}
+	}, nil
+}
+
+// SubmitAfter enqueues f/arg to run once, after d elapses. The returned
+// cancel removes the pending task if it hasn't fired yet
+func (this *Pool) SubmitAfter(d time.Duration, f TaskFunc, arg interface{}) (cancel func(), err error) {
+	return this.schedule(time.Now().Add(d), 0, f, arg)
+}
+
+// SubmitAt enqueues f/arg to run once, at t. The returned cancel removes the
+// pending task if it hasn't fired yet
+func (this *Pool) SubmitAt(t time.Time, f TaskFunc, arg interface{}) (cancel func(), err error) {
+	return this.schedule(t, 0, f, arg)
+}
+
+// SubmitEvery enqueues f/arg to run repeatedly every d, until canceled or the
+// pool closes. The returned cancel stops future firings
+func (this *Pool) SubmitEvery(d time.Duration, f TaskFunc, arg interface{}) (cancel func(), err error) {
+	return this.schedule(time.Now().Add(d), d, f, arg)
+}